@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Batch runs a sequence of parcel operations inside a single transaction
+// using prepared statements, giving callers all-or-nothing semantics (e.g.
+// a courier scanning a whole truckload of packages as sent) without one
+// round trip per parcel.
+type Batch interface {
+	AddMany(ctx context.Context, parcels []Parcel) ([]int, error)
+	SetStatusMany(ctx context.Context, numbers []int, status ParcelStatus) error
+	DeleteMany(ctx context.Context, numbers []int) error
+}
+
+// WithTx runs fn against a ParcelStore whose operations all share a single
+// transaction: if fn returns an error, every write it made is rolled back.
+func WithTx(ctx context.Context, store ParcelStore, fn func(tx ParcelStore) error) error {
+	switch s := store.(type) {
+	case sqliteParcelStore:
+		return s.withTx(ctx, fn)
+	case postgresParcelStore:
+		return s.withTx(ctx, fn)
+	default:
+		return fmt.Errorf("WithTx: unsupported ParcelStore %T", store)
+	}
+}
+
+// WithBatch runs fn against a Batch backed by the same store, committing
+// all of its writes together or rolling all of them back if fn returns an
+// error.
+func WithBatch(ctx context.Context, store ParcelStore, fn func(b Batch) error) error {
+	switch s := store.(type) {
+	case sqliteParcelStore:
+		return s.withBatch(ctx, fn)
+	case postgresParcelStore:
+		return s.withBatch(ctx, fn)
+	default:
+		return fmt.Errorf("WithBatch: unsupported ParcelStore %T", store)
+	}
+}
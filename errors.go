@@ -0,0 +1,22 @@
+package main
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when an operation targets a parcel number that
+	// doesn't exist.
+	ErrNotFound = errors.New("parcel not found")
+
+	// ErrInvalidStatus is returned when SetStatus is called with a status
+	// the store doesn't recognize.
+	ErrInvalidStatus = errors.New("invalid parcel status")
+
+	// ErrImmutable is returned when the address or existence of a parcel is
+	// changed after it has left the registered state.
+	ErrImmutable = errors.New("parcel can no longer be changed")
+
+	// ErrInvalidTransition is returned when SetStatus is asked to move a
+	// parcel between statuses that aren't adjacent in the status state
+	// machine (e.g. delivered -> sent, or registered -> delivered).
+	ErrInvalidTransition = errors.New("invalid parcel status transition")
+)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	_ "modernc.org/sqlite"
+)
+
+// newParcelStore opens the backend selected by PARCEL_DB_DRIVER ("sqlite" or
+// "postgres", defaulting to "sqlite") and runs its migration.
+func newParcelStore(ctx context.Context) (ParcelStore, error) {
+	driver := os.Getenv("PARCEL_DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite"
+	}
+
+	switch driver {
+	case "sqlite":
+		dsn := os.Getenv("PARCEL_DB_DSN")
+		if dsn == "" {
+			dsn = "tracker.db"
+		}
+
+		db, err := sql.Open("sqlite", withSQLiteDSNPragmas(dsn))
+		if err != nil {
+			return nil, fmt.Errorf("open sqlite: %w", err)
+		}
+		if err := MigrateSQLite(db); err != nil {
+			return nil, err
+		}
+
+		return NewSQLiteParcelStore(db), nil
+
+	case "postgres":
+		dsn := os.Getenv("PARCEL_DB_DSN")
+		if dsn == "" {
+			return nil, fmt.Errorf("PARCEL_DB_DSN is required for the postgres driver")
+		}
+
+		pool, err := pgxpool.New(ctx, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open postgres pool: %w", err)
+		}
+		if err := MigratePostgres(ctx, pool); err != nil {
+			return nil, err
+		}
+
+		return NewPostgresParcelStore(pool), nil
+
+	default:
+		return nil, fmt.Errorf("unknown PARCEL_DB_DRIVER %q", driver)
+	}
+}
+
+func main() {
+	ctx := context.Background()
+
+	store, err := newParcelStore(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := 1000
+	number, err := store.Add(ctx, Parcel{
+		Client:    client,
+		Status:    ParcelStatusRegistered,
+		Address:   "Moscow, Dolgoprudny, 3rd Novy lane, 1",
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("registered parcel %d for client %d\n", number, client)
+}
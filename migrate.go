@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS parcel (
+	number     INTEGER PRIMARY KEY AUTOINCREMENT,
+	client     INTEGER,
+	status     TEXT,
+	address    TEXT,
+	created_at DATETIME
+)`
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS parcel (
+	number     BIGSERIAL PRIMARY KEY,
+	client     BIGINT NOT NULL,
+	status     TEXT NOT NULL,
+	address    TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+)`
+
+// sqliteHistorySchema and postgresHistorySchema back ParcelStore.History: one
+// row per status transition, written in the same transaction as the update
+// it records.
+const sqliteHistorySchema = `
+CREATE TABLE IF NOT EXISTS parcel_status_history (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	parcel_number INTEGER REFERENCES parcel(number),
+	from_status   TEXT,
+	to_status     TEXT,
+	changed_at    DATETIME,
+	actor         TEXT
+)`
+
+const postgresHistorySchema = `
+CREATE TABLE IF NOT EXISTS parcel_status_history (
+	id            BIGSERIAL PRIMARY KEY,
+	parcel_number BIGINT NOT NULL REFERENCES parcel(number),
+	from_status   TEXT NOT NULL,
+	to_status     TEXT NOT NULL,
+	changed_at    TIMESTAMPTZ NOT NULL,
+	actor         TEXT NOT NULL
+)`
+
+// clientQueryIndex speeds up Query's per-client, keyset-paginated lookups,
+// which filter on client and order/seek on (created_at, number).
+const clientQueryIndex = `
+CREATE INDEX IF NOT EXISTS idx_parcel_client_created_number ON parcel (client, created_at, number)`
+
+// MigrateSQLite creates the schema used by the sqlite-backed store. It is
+// idempotent and safe to call on every process start.
+func MigrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("migrate sqlite schema: %w", err)
+	}
+	if _, err := db.Exec(clientQueryIndex); err != nil {
+		return fmt.Errorf("migrate sqlite schema: %w", err)
+	}
+	if _, err := db.Exec(sqliteHistorySchema); err != nil {
+		return fmt.Errorf("migrate sqlite schema: %w", err)
+	}
+	return nil
+}
+
+// MigratePostgres creates the schema used by the Postgres/CockroachDB-backed
+// store. It is idempotent and safe to call on every process start.
+func MigratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	if _, err := pool.Exec(ctx, postgresSchema); err != nil {
+		return fmt.Errorf("migrate postgres schema: %w", err)
+	}
+	if _, err := pool.Exec(ctx, clientQueryIndex); err != nil {
+		return fmt.Errorf("migrate postgres schema: %w", err)
+	}
+	if _, err := pool.Exec(ctx, postgresHistorySchema); err != nil {
+		return fmt.Errorf("migrate postgres schema: %w", err)
+	}
+	return nil
+}
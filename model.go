@@ -0,0 +1,69 @@
+package main
+
+import "time"
+
+// ParcelStatus is the lifecycle state of a parcel.
+type ParcelStatus string
+
+const (
+	ParcelStatusRegistered ParcelStatus = "registered"
+	ParcelStatusSent       ParcelStatus = "sent"
+	ParcelStatusDelivered  ParcelStatus = "delivered"
+	ParcelStatusCancelled  ParcelStatus = "cancelled"
+)
+
+// Parcel is a single shipment tracked by the system.
+type Parcel struct {
+	Number    int
+	Client    int
+	Status    ParcelStatus
+	Address   string
+	CreatedAt time.Time
+}
+
+// SortOrder controls how Query results are ordered. A pagination cursor is
+// only meaningful relative to the SortOrder it was produced with.
+type SortOrder int
+
+const (
+	OrderCreatedAtAsc SortOrder = iota
+	OrderCreatedAtDesc
+	OrderNumberAsc
+	OrderNumberDesc
+)
+
+// Cursor marks a position in a keyset-paginated Query result on
+// (created_at, number), so the next page can resume right after the last
+// parcel of the previous one instead of re-scanning from the start.
+type Cursor struct {
+	CreatedAt time.Time
+	Number    int
+}
+
+// ParcelFilter narrows and paginates a call to ParcelStore.Query.
+type ParcelFilter struct {
+	Client        int
+	Status        *ParcelStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	AddressLike   string
+	OrderBy       SortOrder
+	// Limit caps the page size; zero or negative falls back to
+	// defaultQueryLimit.
+	Limit int
+	// After, when set, resumes a previous Query from the Cursor it
+	// returned.
+	After *Cursor
+}
+
+// StatusChange is one recorded transition in a parcel's status history, as
+// returned by ParcelStore.History.
+type StatusChange struct {
+	ParcelNumber int
+	From         ParcelStatus
+	To           ParcelStatus
+	ChangedAt    time.Time
+	// Actor identifies who made the change (see ContextWithActor), or
+	// "system" if the caller didn't set one.
+	Actor string
+}
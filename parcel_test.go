@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"math/rand"
+	"os"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/require"
 	_ "modernc.org/sqlite"
 )
@@ -24,149 +31,802 @@ func getTestParcel() Parcel {
 	}
 }
 
-func setupDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite", ":memory:")
-	require.NoError(t, err)
+// newSQLiteStore sets up an in-memory sqlite-backed store for a single test.
+func newSQLiteStore(t *testing.T) ParcelStore {
+	t.Helper()
+
+	// A bare ":memory:" database lives on whichever single connection
+	// creates it, so a pool handing out more than one would silently
+	// scatter the schema and data across unrelated databases.
+	// mode=memory&cache=shared instead gives every connection the pool
+	// opens a view of the same in-memory database; the random name keeps
+	// this test's database from colliding with another test's inside that
+	// shared cache.
+	dsn := withSQLiteDSNPragmas(fmt.Sprintf("file:sqlite_test_%d?mode=memory&cache=shared", randRange.Int63()))
 
-	_, err = db.Exec("CREATE TABLE parcel (number INTEGER PRIMARY KEY AUTOINCREMENT, client INTEGER, status TEXT, address TEXT, created_at DATETIME)")
+	db, err := sql.Open("sqlite", dsn)
 	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// A handful of real connections rather than one, so tests that fire
+	// concurrent SetStatus calls (see
+	// TestSetStatusConcurrentTransitionIsSerialized) genuinely race each
+	// other instead of being serialized for free by the pool before any
+	// SQL runs.
+	db.SetMaxOpenConns(4)
 
-	return db
+	require.NoError(t, MigrateSQLite(db))
+
+	return NewSQLiteParcelStore(db)
 }
 
-func TestAddGetDelete(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+// newPostgresStore sets up a Postgres-backed store for a single test,
+// against the database named by PARCEL_TEST_POSTGRES_DSN. Tests are skipped
+// when that DSN isn't set, since spinning up Postgres/CockroachDB is left to
+// the environment (e.g. testcontainers, or a CI service container) rather
+// than this package.
+func newPostgresStore(t *testing.T) ParcelStore {
+	t.Helper()
+
+	dsn := os.Getenv("PARCEL_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("PARCEL_TEST_POSTGRES_DSN not set, skipping postgres backend")
+	}
 
-	store := NewParcelStore(db)
-	parcel := getTestParcel()
+	ctx := context.Background()
 
-	id, err := store.Add(parcel)
+	pool, err := pgxpool.New(ctx, dsn)
 	require.NoError(t, err)
-	require.NotZero(t, id)
+	t.Cleanup(pool.Close)
 
-	p, err := store.Get(id)
+	require.NoError(t, MigratePostgres(ctx, pool))
+	_, err = pool.Exec(ctx, "TRUNCATE TABLE parcel")
 	require.NoError(t, err)
-	require.Equal(t, id, p.Number)
-	require.Equal(t, parcel.Client, p.Client)
-	require.Equal(t, parcel.Status, p.Status)
-	require.Equal(t, parcel.Address, p.Address)
-	require.False(t, p.CreatedAt.IsZero())
 
-	err = store.Delete(id)
-	require.NoError(t, err)
+	return NewPostgresParcelStore(pool)
+}
 
-	_, err = store.Get(id)
-	require.ErrorIs(t, err, sql.ErrNoRows)
+// backends lists every ParcelStore implementation the suite below runs
+// against.
+var backends = []struct {
+	name  string
+	setup func(t *testing.T) ParcelStore
+}{
+	{"sqlite", newSQLiteStore},
+	{"postgres", newPostgresStore},
+}
+
+func TestAddGetDelete(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
+
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
+			require.NotZero(t, id)
+
+			p, err := store.Get(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, id, p.Number)
+			require.Equal(t, parcel.Client, p.Client)
+			require.Equal(t, parcel.Status, p.Status)
+			require.Equal(t, parcel.Address, p.Address)
+			require.False(t, p.CreatedAt.IsZero())
+
+			err = store.Delete(ctx, id)
+			require.NoError(t, err)
+
+			_, err = store.Get(ctx, id)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
 }
 
 func TestSetAddress(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
+
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
+
+			newAddress := "new test address"
+			err = store.SetAddress(ctx, id, newAddress)
+			require.NoError(t, err)
+
+			p, err := store.Get(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, newAddress, p.Address)
+		})
+	}
+}
 
-	store := NewParcelStore(db)
-	parcel := getTestParcel()
+func TestSetAddressOnSentParcel(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
 
-	id, err := store.Add(parcel)
-	require.NoError(t, err)
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
 
-	newAddress := "new test address"
-	err = store.SetAddress(id, newAddress)
-	require.NoError(t, err)
+			err = store.SetStatus(ctx, id, ParcelStatusSent)
+			require.NoError(t, err)
 
-	p, err := store.Get(id)
-	require.NoError(t, err)
-	require.Equal(t, newAddress, p.Address)
+			err = store.SetAddress(ctx, id, "new_address")
+			require.ErrorIs(t, err, ErrImmutable)
+		})
+	}
 }
 
-func TestSetAddressOnSentParcel(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+func TestSetStatus(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
+
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
+
+			err = store.SetStatus(ctx, id, ParcelStatusSent)
+			require.NoError(t, err)
+
+			p, err := store.Get(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p.Status)
+		})
+	}
+}
 
-	store := NewParcelStore(db)
-	parcel := getTestParcel()
+func TestDeleteSentParcel(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
 
-	id, err := store.Add(parcel)
-	require.NoError(t, err)
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
 
-	err = store.SetStatus(id, ParcelStatusSent)
-	require.NoError(t, err)
+			err = store.SetStatus(ctx, id, ParcelStatusSent)
+			require.NoError(t, err)
 
-	err = store.SetAddress(id, "new_address")
-	require.ErrorIs(t, err, ErrNotFound)
+			err = store.Delete(ctx, id)
+			require.ErrorIs(t, err, ErrImmutable)
+		})
+	}
 }
 
-func TestSetStatus(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+func TestSetStatusInvalid(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
 
-	store := NewParcelStore(db)
-	parcel := getTestParcel()
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
 
-	id, err := store.Add(parcel)
-	require.NoError(t, err)
+			err = store.SetStatus(ctx, id, ParcelStatus("lost"))
+			require.ErrorIs(t, err, ErrInvalidStatus)
+		})
+	}
+}
 
-	err = store.SetStatus(id, ParcelStatusSent)
-	require.NoError(t, err)
+func TestSetStatusInvalidTransition(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
 
-	p, err := store.Get(id)
-	require.NoError(t, err)
-	require.Equal(t, ParcelStatusSent, p.Status)
-}
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
 
-func TestDeleteSentParcel(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+			// registered -> delivered skips the "sent" step.
+			err = store.SetStatus(ctx, id, ParcelStatusDelivered)
+			require.ErrorIs(t, err, ErrInvalidTransition)
 
-	store := NewParcelStore(db)
-	parcel := getTestParcel()
+			require.NoError(t, store.SetStatus(ctx, id, ParcelStatusCancelled))
 
-	id, err := store.Add(parcel)
-	require.NoError(t, err)
+			// cancelled is terminal: nothing may follow it.
+			err = store.SetStatus(ctx, id, ParcelStatusSent)
+			require.ErrorIs(t, err, ErrInvalidTransition)
 
-	err = store.SetStatus(id, ParcelStatusSent)
-	require.NoError(t, err)
+			// delivered is also terminal: nothing may follow it either, not
+			// even re-entering a status already passed through.
+			deliveredID, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
+			require.NoError(t, store.SetStatus(ctx, deliveredID, ParcelStatusSent))
+			require.NoError(t, store.SetStatus(ctx, deliveredID, ParcelStatusDelivered))
 
-	err = store.Delete(id)
-	require.ErrorIs(t, err, ErrNotFound)
+			err = store.SetStatus(ctx, deliveredID, ParcelStatusSent)
+			require.ErrorIs(t, err, ErrInvalidTransition)
+		})
+	}
+}
+
+// TestSetStatusConcurrentTransitionIsSerialized fires two SetStatus calls for
+// the same registered -> sent transition at once. Without the read-check-
+// write happening as one atomic unit, both could read "registered", both
+// pass validTransition, and both commit a status-history row claiming
+// "registered" as the from-status. Only one may win.
+func TestSetStatusConcurrentTransitionIsSerialized(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			id, err := store.Add(ctx, getTestParcel())
+			require.NoError(t, err)
+
+			var wg sync.WaitGroup
+			results := make([]error, 2)
+			wg.Add(len(results))
+			for i := range results {
+				i := i
+				go func() {
+					defer wg.Done()
+					results[i] = store.SetStatus(ctx, id, ParcelStatusSent)
+				}()
+			}
+			wg.Wait()
+
+			// The loser sees ErrInvalidTransition if its read happened after
+			// the winner's commit (it then sees "sent" and sent -> sent is
+			// invalid), or ErrConcurrentUpdate if its read raced the
+			// winner's write (its guarded UPDATE then affects zero rows).
+			// Either way, exactly one call wins and no stale "from" status
+			// ever reaches parcel_status_history.
+			succeeded := 0
+			for _, err := range results {
+				if err == nil {
+					succeeded++
+					continue
+				}
+				if !errors.Is(err, ErrInvalidTransition) && !errors.Is(err, ErrConcurrentUpdate) {
+					t.Fatalf("unexpected error from losing SetStatus call: %v", err)
+				}
+			}
+			require.Equal(t, 1, succeeded, "exactly one of two concurrent transitions should win")
+
+			p, err := store.Get(ctx, id)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p.Status)
+
+			history, err := store.History(ctx, id)
+			require.NoError(t, err)
+			require.Len(t, history, 1)
+			require.Equal(t, ParcelStatusRegistered, history[0].From)
+			require.Equal(t, ParcelStatusSent, history[0].To)
+		})
+	}
+}
+
+func TestHistoryRecordsTransitions(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcel := getTestParcel()
+
+			id, err := store.Add(ctx, parcel)
+			require.NoError(t, err)
+
+			actorCtx := ContextWithActor(ctx, "courier-42")
+			require.NoError(t, store.SetStatus(actorCtx, id, ParcelStatusSent))
+			require.NoError(t, store.SetStatus(ctx, id, ParcelStatusDelivered))
+
+			history, err := store.History(ctx, id)
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+
+			require.Equal(t, id, history[0].ParcelNumber)
+			require.Equal(t, ParcelStatusRegistered, history[0].From)
+			require.Equal(t, ParcelStatusSent, history[0].To)
+			require.Equal(t, "courier-42", history[0].Actor)
+			require.False(t, history[0].ChangedAt.IsZero())
+
+			require.Equal(t, ParcelStatusSent, history[1].From)
+			require.Equal(t, ParcelStatusDelivered, history[1].To)
+			require.Equal(t, "system", history[1].Actor)
+		})
+	}
+}
+
+func TestGetNotFoundUnwraps(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			_, err := store.Get(ctx, 999999)
+			require.Error(t, err)
+			require.ErrorIs(t, err, ErrNotFound)
+			require.Contains(t, err.Error(), "999999")
+		})
+	}
 }
 
 func TestGetByClient(t *testing.T) {
-	db := setupDB(t)
-	defer db.Close()
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+			parcels := []Parcel{
+				getTestParcel(),
+				getTestParcel(),
+				getTestParcel(),
+			}
+			parcelMap := map[int]Parcel{}
+
+			client := randRange.Intn(10_000_000)
+			parcels[0].Client = client
+			parcels[1].Client = client
+			parcels[2].Client = client
+
+			for i := 0; i < len(parcels); i++ {
+				id, err := store.Add(ctx, parcels[i])
+				require.NoError(t, err)
+				require.NotZero(t, id)
+
+				parcels[i].Number = id
+				parcelMap[id] = parcels[i]
+			}
+
+			storedParcels, err := store.GetByClient(ctx, client)
+			require.NoError(t, err)
+			require.Len(t, storedParcels, len(parcels))
+
+			for _, parcel := range storedParcels {
+				expectedParcel, exists := parcelMap[parcel.Number]
+				require.True(t, exists)
+				require.Equal(t, expectedParcel.Client, parcel.Client)
+				require.Equal(t, expectedParcel.Status, parcel.Status)
+				require.Equal(t, expectedParcel.Address, parcel.Address)
+				require.False(t, parcel.CreatedAt.IsZero())
+			}
+		})
+	}
+}
 
-	store := NewParcelStore(db)
-	parcels := []Parcel{
-		getTestParcel(),
-		getTestParcel(),
-		getTestParcel(),
+// TestGetByClientCancelledContext cancels the context while GetByClient is
+// actually mid-scan, not before the call starts: the client has enough rows
+// that scanning takes long enough for a cancel fired from another goroutine
+// shortly after the call begins to land partway through, exercising the
+// *sql.Rows cleanup path rather than the trivial "context already dead"
+// rejection.
+func TestGetByClientCancelledContext(t *testing.T) {
+	const rowCount = 30000
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+			parcels := make([]Parcel, rowCount)
+			for i := range parcels {
+				p := getTestParcel()
+				p.Client = client
+				parcels[i] = p
+			}
+			require.NoError(t, WithBatch(ctx, store, func(batch Batch) error {
+				_, err := batch.AddMany(ctx, parcels)
+				return err
+			}))
+
+			goroutinesBefore := runtime.NumGoroutine()
+
+			cancelledCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				time.Sleep(2 * time.Millisecond)
+				cancel()
+			}()
+
+			_, err := store.GetByClient(cancelledCtx, client)
+			require.Error(t, err)
+			require.ErrorIs(t, err, context.Canceled)
+
+			// The rows from the cancelled query must have been closed, not
+			// leaked: a normal query afterwards should still succeed and
+			// return every row, and no goroutines should have piled up.
+			got, err := store.GetByClient(ctx, client)
+			require.NoError(t, err)
+			require.Len(t, got, rowCount)
+
+			require.Eventually(t, func() bool {
+				return runtime.NumGoroutine() <= goroutinesBefore+1
+			}, time.Second, 10*time.Millisecond, "goroutines leaked after cancelled GetByClient")
+		})
 	}
-	parcelMap := map[int]Parcel{}
+}
 
-	client := randRange.Intn(10_000_000)
-	parcels[0].Client = client
-	parcels[1].Client = client
-	parcels[2].Client = client
+func TestQueryOrdering(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+			var numbers []int
+			for i := 0; i < 5; i++ {
+				parcel := getTestParcel()
+				parcel.Client = client
+				id, err := store.Add(ctx, parcel)
+				require.NoError(t, err)
+				numbers = append(numbers, id)
+			}
+
+			asc, next, err := store.Query(ctx, ParcelFilter{Client: client, OrderBy: OrderNumberAsc})
+			require.NoError(t, err)
+			require.Nil(t, next)
+			require.Len(t, asc, 5)
+			for i, p := range asc {
+				require.Equal(t, numbers[i], p.Number)
+			}
+
+			desc, next, err := store.Query(ctx, ParcelFilter{Client: client, OrderBy: OrderNumberDesc})
+			require.NoError(t, err)
+			require.Nil(t, next)
+			require.Len(t, desc, 5)
+			for i, p := range desc {
+				require.Equal(t, numbers[len(numbers)-1-i], p.Number)
+			}
+		})
+	}
+}
 
-	for i := 0; i < len(parcels); i++ {
-		id, err := store.Add(parcels[i])
-		require.NoError(t, err)
-		require.NotZero(t, id)
+// TestQueryOrderingCreatedAt exercises OrderCreatedAtAsc (the zero value of
+// SortOrder, so also what any caller gets by leaving OrderBy unset) and
+// OrderCreatedAtDesc, with several parcels sharing the same created_at so the
+// (created_at, number) tie-break in the ORDER BY/seek clause actually runs.
+func TestQueryOrderingCreatedAt(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+			base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			// Two ties (same created_at, different numbers) followed by a
+			// distinct timestamp.
+			timestamps := []time.Time{base, base, base.Add(time.Second), base.Add(time.Second), base.Add(2 * time.Second)}
+
+			var numbers []int
+			for _, ts := range timestamps {
+				parcel := getTestParcel()
+				parcel.Client = client
+				parcel.CreatedAt = ts
+				id, err := store.Add(ctx, parcel)
+				require.NoError(t, err)
+				numbers = append(numbers, id)
+			}
+
+			// Unset OrderBy must behave exactly like OrderCreatedAtAsc.
+			asc, next, err := store.Query(ctx, ParcelFilter{Client: client})
+			require.NoError(t, err)
+			require.Nil(t, next)
+			require.Equal(t, numbers, numbersOf(asc))
+
+			ascExplicit, next, err := store.Query(ctx, ParcelFilter{Client: client, OrderBy: OrderCreatedAtAsc})
+			require.NoError(t, err)
+			require.Nil(t, next)
+			require.Equal(t, numbers, numbersOf(ascExplicit))
+
+			desc, next, err := store.Query(ctx, ParcelFilter{Client: client, OrderBy: OrderCreatedAtDesc})
+			require.NoError(t, err)
+			require.Nil(t, next)
+			reversed := make([]int, len(numbers))
+			for i, n := range numbers {
+				reversed[len(numbers)-1-i] = n
+			}
+			require.Equal(t, reversed, numbersOf(desc))
+		})
+	}
+}
 
-		parcels[i].Number = id
-		parcelMap[id] = parcels[i]
+// numbersOf extracts parcel numbers in order, for comparing Query results
+// against an expected sequence.
+func numbersOf(parcels []Parcel) []int {
+	numbers := make([]int, len(parcels))
+	for i, p := range parcels {
+		numbers[i] = p.Number
 	}
+	return numbers
+}
 
-	storedParcels, err := store.GetByClient(client)
-	require.NoError(t, err)
-	require.Len(t, storedParcels, len(parcels))
-
-	for _, parcel := range storedParcels {
-		expectedParcel, exists := parcelMap[parcel.Number]
-		require.True(t, exists)
-		require.Equal(t, expectedParcel.Client, parcel.Client)
-		require.Equal(t, expectedParcel.Status, parcel.Status)
-		require.Equal(t, expectedParcel.Address, parcel.Address)
-		require.False(t, parcel.CreatedAt.IsZero())
+func TestQueryCursorPagination(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+			var numbers []int
+			for i := 0; i < 10; i++ {
+				parcel := getTestParcel()
+				parcel.Client = client
+				id, err := store.Add(ctx, parcel)
+				require.NoError(t, err)
+				numbers = append(numbers, id)
+			}
+
+			var seen []int
+			filter := ParcelFilter{Client: client, OrderBy: OrderNumberAsc, Limit: 3}
+			for {
+				page, next, err := store.Query(ctx, filter)
+				require.NoError(t, err)
+				for _, p := range page {
+					seen = append(seen, p.Number)
+				}
+				if next == nil {
+					break
+				}
+				filter.After = next
+			}
+
+			require.Equal(t, numbers, seen)
+		})
+	}
+}
+
+// TestQueryCursorPaginationCreatedAt pages through results ordered by
+// created_at with several parcels sharing the same timestamp, so a page
+// boundary can land in the middle of a tie group. The cursor round-trip
+// must still produce every parcel exactly once, in (created_at, number)
+// order, regardless of where a page happens to end.
+func TestQueryCursorPaginationCreatedAt(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+			base := time.Date(2024, 6, 15, 9, 30, 0, 0, time.UTC)
+			// Three parcels share base, two share base+1s, one is alone at
+			// base+2s: with Limit: 2, page boundaries fall both inside and
+			// between tie groups.
+			timestamps := []time.Time{
+				base, base, base,
+				base.Add(time.Second), base.Add(time.Second),
+				base.Add(2 * time.Second),
+			}
+
+			var numbers []int
+			for _, ts := range timestamps {
+				parcel := getTestParcel()
+				parcel.Client = client
+				parcel.CreatedAt = ts
+				id, err := store.Add(ctx, parcel)
+				require.NoError(t, err)
+				numbers = append(numbers, id)
+			}
+
+			var seen []int
+			filter := ParcelFilter{Client: client, OrderBy: OrderCreatedAtAsc, Limit: 2}
+			for {
+				page, next, err := store.Query(ctx, filter)
+				require.NoError(t, err)
+				seen = append(seen, numbersOf(page)...)
+				if next == nil {
+					break
+				}
+				filter.After = next
+			}
+
+			require.Equal(t, numbers, seen)
+		})
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			client := randRange.Intn(10_000_000)
+
+			sentParcel := getTestParcel()
+			sentParcel.Client = client
+			sentParcel.Address = "123 Main St"
+			sentID, err := store.Add(ctx, sentParcel)
+			require.NoError(t, err)
+			require.NoError(t, store.SetStatus(ctx, sentID, ParcelStatusSent))
+
+			registeredParcel := getTestParcel()
+			registeredParcel.Client = client
+			registeredParcel.Address = "456 Other Ave"
+			_, err = store.Add(ctx, registeredParcel)
+			require.NoError(t, err)
+
+			sentStatus := ParcelStatusSent
+			byStatus, _, err := store.Query(ctx, ParcelFilter{Client: client, Status: &sentStatus})
+			require.NoError(t, err)
+			require.Len(t, byStatus, 1)
+			require.Equal(t, sentID, byStatus[0].Number)
+
+			byAddress, _, err := store.Query(ctx, ParcelFilter{Client: client, AddressLike: "Main"})
+			require.NoError(t, err)
+			require.Len(t, byAddress, 1)
+			require.Equal(t, sentID, byAddress[0].Number)
+		})
+	}
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			errBoom := errors.New("boom")
+			err := WithTx(ctx, store, func(tx ParcelStore) error {
+				if _, err := tx.Add(ctx, getTestParcel()); err != nil {
+					return err
+				}
+				return errBoom
+			})
+			require.ErrorIs(t, err, errBoom)
+
+			parcels, err := store.GetByClient(ctx, 1000)
+			require.NoError(t, err)
+			require.Empty(t, parcels)
+		})
+	}
+}
+
+func TestWithTxCommitsOnSuccess(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			var id int
+			err := WithTx(ctx, store, func(tx ParcelStore) error {
+				var err error
+				id, err = tx.Add(ctx, getTestParcel())
+				return err
+			})
+			require.NoError(t, err)
+
+			_, err = store.Get(ctx, id)
+			require.NoError(t, err)
+		})
+	}
+}
+
+// insertOrphanHistoryRow writes a parcel_status_history row for a parcel
+// number that doesn't exist, bypassing every application-level check so
+// the database's own foreign key is what rejects it.
+func insertOrphanHistoryRow(ctx context.Context, store ParcelStore, number int) error {
+	switch s := store.(type) {
+	case sqliteParcelStore:
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES (?, ?, ?, ?, ?)",
+			number, ParcelStatusRegistered, ParcelStatusSent, time.Now().UTC(), "system")
+		return err
+	case postgresParcelStore:
+		_, err := s.db.Exec(ctx,
+			"INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES ($1, $2, $3, $4, $5)",
+			number, ParcelStatusRegistered, ParcelStatusSent, time.Now().UTC(), "system")
+		return err
+	default:
+		return fmt.Errorf("insertOrphanHistoryRow: unsupported ParcelStore %T", store)
+	}
+}
+
+// TestWithTxRollsBackOnForeignKeyViolation exercises
+// parcel_status_history's foreign key to parcel: a history row for a
+// parcel number that doesn't exist must be rejected, and that failure
+// must roll back the rest of its transaction too, not just the one
+// statement that violated the constraint.
+func TestWithTxRollsBackOnForeignKeyViolation(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			const missingParcelNumber = 999999
+
+			err := WithTx(ctx, store, func(tx ParcelStore) error {
+				if _, err := tx.Add(ctx, getTestParcel()); err != nil {
+					return err
+				}
+				return insertOrphanHistoryRow(ctx, tx, missingParcelNumber)
+			})
+			require.Error(t, err)
+
+			// The Add before the foreign key violation must have been
+			// rolled back along with it: the batch is all-or-nothing.
+			parcels, err := store.GetByClient(ctx, 1000)
+			require.NoError(t, err)
+			require.Empty(t, parcels)
+		})
+	}
+}
+
+func TestBatchAddMany(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			parcels := []Parcel{getTestParcel(), getTestParcel(), getTestParcel()}
+
+			var ids []int
+			err := WithBatch(ctx, store, func(batch Batch) error {
+				var err error
+				ids, err = batch.AddMany(ctx, parcels)
+				return err
+			})
+			require.NoError(t, err)
+			require.Len(t, ids, len(parcels))
+
+			for _, id := range ids {
+				_, err := store.Get(ctx, id)
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBatchRollsBackOnMixedValidAndImmutable(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			registeredID, err := store.Add(ctx, getTestParcel())
+			require.NoError(t, err)
+			sentID, err := store.Add(ctx, getTestParcel())
+			require.NoError(t, err)
+			require.NoError(t, store.SetStatus(ctx, sentID, ParcelStatusSent))
+
+			err = WithBatch(ctx, store, func(batch Batch) error {
+				return batch.DeleteMany(ctx, []int{registeredID, sentID})
+			})
+			require.ErrorIs(t, err, ErrImmutable)
+
+			// Neither delete should have landed: the batch is all-or-nothing.
+			_, err = store.Get(ctx, registeredID)
+			require.NoError(t, err)
+			_, err = store.Get(ctx, sentID)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestBatchSetStatusMany(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := b.setup(t)
+
+			id1, err := store.Add(ctx, getTestParcel())
+			require.NoError(t, err)
+			id2, err := store.Add(ctx, getTestParcel())
+			require.NoError(t, err)
+
+			err = WithBatch(ctx, store, func(batch Batch) error {
+				return batch.SetStatusMany(ctx, []int{id1, id2}, ParcelStatusSent)
+			})
+			require.NoError(t, err)
+
+			p1, err := store.Get(ctx, id1)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p1.Status)
+
+			p2, err := store.Get(ctx, id2)
+			require.NoError(t, err)
+			require.Equal(t, ParcelStatusSent, p2.Status)
+		})
 	}
 }
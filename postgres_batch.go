@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresBatch is the Postgres/CockroachDB-backed Batch, built on prepared
+// statements on the transaction's own connection so a large batch doesn't
+// pay per-statement parse overhead.
+type postgresBatch struct {
+	tx pgx.Tx
+}
+
+func newPostgresBatch(ctx context.Context, tx pgx.Tx) (*postgresBatch, error) {
+	conn := tx.Conn()
+
+	stmts := []struct{ name, sql string }{
+		{"batch_add", "INSERT INTO parcel (client, status, address, created_at) VALUES ($1, $2, $3, $4) RETURNING number"},
+		{"batch_get_status", "SELECT status FROM parcel WHERE number = $1"},
+		{"batch_set_status", "UPDATE parcel SET status = $1 WHERE number = $2"},
+		{"batch_history", "INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES ($1, $2, $3, $4, $5)"},
+		{"batch_delete", "DELETE FROM parcel WHERE number = $1"},
+	}
+	for _, stmt := range stmts {
+		if _, err := conn.Prepare(ctx, stmt.name, stmt.sql); err != nil {
+			return nil, err
+		}
+	}
+
+	return &postgresBatch{tx: tx}, nil
+}
+
+func (b *postgresBatch) status(ctx context.Context, number int) (ParcelStatus, error) {
+	var status ParcelStatus
+	err := b.tx.QueryRow(ctx, "batch_get_status", number).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+		}
+		return "", fmt.Errorf("get parcel %d: %w", number, err)
+	}
+
+	return status, nil
+}
+
+func (b *postgresBatch) AddMany(ctx context.Context, parcels []Parcel) ([]int, error) {
+	ids := make([]int, 0, len(parcels))
+	for _, p := range parcels {
+		var id int
+		err := b.tx.QueryRow(ctx, "batch_add", p.Client, p.Status, p.Address, p.CreatedAt).Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("add parcel: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (b *postgresBatch) SetStatusMany(ctx context.Context, numbers []int, status ParcelStatus) error {
+	if err := validateStatus(status); err != nil {
+		return err
+	}
+
+	for _, number := range numbers {
+		current, err := b.status(ctx, number)
+		if err != nil {
+			return err
+		}
+		if !validTransition(current, status) {
+			return ErrInvalidTransition
+		}
+
+		if _, err := b.tx.Exec(ctx, "batch_set_status", status, number); err != nil {
+			return fmt.Errorf("set status for parcel %d: %w", number, err)
+		}
+		if _, err := b.tx.Exec(ctx, "batch_history", number, current, status, time.Now().UTC(), actorFromContext(ctx)); err != nil {
+			return fmt.Errorf("record status history for parcel %d: %w", number, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *postgresBatch) DeleteMany(ctx context.Context, numbers []int) error {
+	for _, number := range numbers {
+		status, err := b.status(ctx, number)
+		if err != nil {
+			return err
+		}
+		if status != ParcelStatusRegistered {
+			return ErrImmutable
+		}
+
+		if _, err := b.tx.Exec(ctx, "batch_delete", number); err != nil {
+			return fmt.Errorf("delete parcel %d: %w", number, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgxExecer is satisfied by both *pgxpool.Pool and pgx.Tx, so
+// postgresParcelStore can run unchanged against a plain pool connection or
+// inside a transaction.
+type pgxExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// postgresParcelStore is the pgxpool-backed ParcelStore, for Postgres or
+// CockroachDB. It implements the same ParcelStore interface as
+// sqliteParcelStore, so the rest of the codebase is agnostic to which
+// backend is in use.
+type postgresParcelStore struct {
+	db pgxExecer
+}
+
+// NewPostgresParcelStore returns a ParcelStore backed by a pgxpool.Pool. The
+// caller is responsible for opening the pool and running MigratePostgres
+// first.
+func NewPostgresParcelStore(pool *pgxpool.Pool) ParcelStore {
+	return postgresParcelStore{db: pool}
+}
+
+func (s postgresParcelStore) Add(ctx context.Context, p Parcel) (int, error) {
+	var id int
+	err := s.db.QueryRow(ctx,
+		"INSERT INTO parcel (client, status, address, created_at) VALUES ($1, $2, $3, $4) RETURNING number",
+		p.Client, p.Status, p.Address, p.CreatedAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("add parcel: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s postgresParcelStore) Get(ctx context.Context, number int) (Parcel, error) {
+	row := s.db.QueryRow(ctx,
+		"SELECT number, client, status, address, created_at FROM parcel WHERE number = $1", number)
+
+	var p Parcel
+	err := row.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Parcel{}, fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+		}
+		return Parcel{}, fmt.Errorf("get parcel %d: %w", number, err)
+	}
+
+	return p, nil
+}
+
+func (s postgresParcelStore) GetByClient(ctx context.Context, client int) ([]Parcel, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT number, client, status, address, created_at FROM parcel WHERE client = $1", client)
+	if err != nil {
+		return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+	}
+	defer rows.Close()
+
+	var parcels []Parcel
+	for rows.Next() {
+		var p Parcel
+		if err := rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+		}
+		parcels = append(parcels, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+	}
+
+	return parcels, nil
+}
+
+func (s postgresParcelStore) Query(ctx context.Context, filter ParcelFilter) ([]Parcel, *Cursor, error) {
+	limit := normalizeLimit(filter.Limit)
+
+	query := "SELECT number, client, status, address, created_at FROM parcel WHERE client = $1"
+	args := []any{filter.Client}
+
+	addArg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Status != nil {
+		query += " AND status = " + addArg(*filter.Status)
+	}
+	if filter.CreatedAfter != nil {
+		query += " AND created_at > " + addArg(*filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query += " AND created_at < " + addArg(*filter.CreatedBefore)
+	}
+	if filter.AddressLike != "" {
+		query += " AND address LIKE " + addArg("%"+filter.AddressLike+"%")
+	}
+	if filter.After != nil {
+		switch filter.OrderBy {
+		case OrderCreatedAtDesc:
+			created, number := addArg(filter.After.CreatedAt), addArg(filter.After.Number)
+			query += fmt.Sprintf(" AND (created_at < %s OR (created_at = %s AND number < %s))", created, created, number)
+		case OrderNumberAsc:
+			query += " AND number > " + addArg(filter.After.Number)
+		case OrderNumberDesc:
+			query += " AND number < " + addArg(filter.After.Number)
+		default: // OrderCreatedAtAsc
+			created, number := addArg(filter.After.CreatedAt), addArg(filter.After.Number)
+			query += fmt.Sprintf(" AND (created_at > %s OR (created_at = %s AND number > %s))", created, created, number)
+		}
+	}
+
+	query += " ORDER BY " + orderByClause(filter.OrderBy) + " LIMIT " + addArg(limit+1)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+	}
+	defer rows.Close()
+
+	var parcels []Parcel
+	for rows.Next() {
+		var p Parcel
+		if err := rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt); err != nil {
+			return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+		}
+		parcels = append(parcels, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+	}
+
+	var next *Cursor
+	if len(parcels) > limit {
+		parcels = parcels[:limit]
+		last := parcels[len(parcels)-1]
+		next = &Cursor{CreatedAt: last.CreatedAt, Number: last.Number}
+	}
+
+	return parcels, next, nil
+}
+
+func (s postgresParcelStore) SetStatus(ctx context.Context, number int, status ParcelStatus) error {
+	if err := validateStatus(status); err != nil {
+		return err
+	}
+
+	// FOR UPDATE locks the row for the lifetime of the transaction, so the
+	// read, transition check, and write are one atomic unit: a concurrent
+	// SetStatus on the same parcel blocks until this one commits or rolls
+	// back, instead of racing on a stale "from" status.
+	apply := func(db pgxExecer) error {
+		row := db.QueryRow(ctx, "SELECT status FROM parcel WHERE number = $1 FOR UPDATE", number)
+		var current ParcelStatus
+		if err := row.Scan(&current); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+			}
+			return fmt.Errorf("get parcel %d: %w", number, err)
+		}
+		if !validTransition(current, status) {
+			return ErrInvalidTransition
+		}
+
+		if _, err := db.Exec(ctx, "UPDATE parcel SET status = $1 WHERE number = $2", status, number); err != nil {
+			return fmt.Errorf("set status for parcel %d: %w", number, err)
+		}
+		_, err := db.Exec(ctx,
+			"INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES ($1, $2, $3, $4, $5)",
+			number, current, status, time.Now().UTC(), actorFromContext(ctx))
+		if err != nil {
+			return fmt.Errorf("record status history for parcel %d: %w", number, err)
+		}
+		return nil
+	}
+
+	pool, ok := s.db.(*pgxpool.Pool)
+	if !ok {
+		// Already running inside a caller's transaction (e.g. WithTx):
+		// both writes land atomically as part of that transaction.
+		return apply(s.db)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := apply(tx); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s postgresParcelStore) History(ctx context.Context, number int) ([]StatusChange, error) {
+	rows, err := s.db.Query(ctx,
+		"SELECT parcel_number, from_status, to_status, changed_at, actor FROM parcel_status_history WHERE parcel_number = $1 ORDER BY id ASC",
+		number)
+	if err != nil {
+		return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+	}
+	defer rows.Close()
+
+	var history []StatusChange
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.ParcelNumber, &c.From, &c.To, &c.ChangedAt, &c.Actor); err != nil {
+			return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+		}
+		history = append(history, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+	}
+
+	return history, nil
+}
+
+func (s postgresParcelStore) SetAddress(ctx context.Context, number int, address string) error {
+	p, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrImmutable
+	}
+
+	if _, err := s.db.Exec(ctx, "UPDATE parcel SET address = $1 WHERE number = $2", address, number); err != nil {
+		return fmt.Errorf("set address for parcel %d: %w", number, err)
+	}
+
+	return nil
+}
+
+func (s postgresParcelStore) Delete(ctx context.Context, number int) error {
+	p, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrImmutable
+	}
+
+	if _, err := s.db.Exec(ctx, "DELETE FROM parcel WHERE number = $1", number); err != nil {
+		return fmt.Errorf("delete parcel %d: %w", number, err)
+	}
+
+	return nil
+}
+
+// withTx runs fn against a postgresParcelStore backed by a single pgx.Tx,
+// committing on success and rolling back if fn (or the commit) fails.
+func (s postgresParcelStore) withTx(ctx context.Context, fn func(tx ParcelStore) error) error {
+	pool, ok := s.db.(*pgxpool.Pool)
+	if !ok {
+		return errors.New("withTx: store is already inside a transaction")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	if err := fn(postgresParcelStore{db: tx}); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// withBatch runs fn against a Batch backed by a single pgx.Tx and prepared
+// statements, committing on success and rolling back if fn (or the commit)
+// fails.
+func (s postgresParcelStore) withBatch(ctx context.Context, fn func(b Batch) error) error {
+	pool, ok := s.db.(*pgxpool.Pool)
+	if !ok {
+		return errors.New("withBatch: store is already inside a transaction")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	b, err := newPostgresBatch(ctx, tx)
+	if err != nil {
+		tx.Rollback(ctx)
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+
+	if err := fn(b); err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sqliteBatch is the sqlite-backed Batch, built on prepared statements so a
+// large batch doesn't pay per-statement parse overhead.
+type sqliteBatch struct {
+	addStmt       *sql.Stmt
+	getStatusStmt *sql.Stmt
+	setStatusStmt *sql.Stmt
+	historyStmt   *sql.Stmt
+	deleteStmt    *sql.Stmt
+}
+
+func newSQLiteBatch(tx *sql.Tx) (*sqliteBatch, error) {
+	addStmt, err := tx.Prepare("INSERT INTO parcel (client, status, address, created_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	getStatusStmt, err := tx.Prepare("SELECT status FROM parcel WHERE number = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	setStatusStmt, err := tx.Prepare("UPDATE parcel SET status = ? WHERE number = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	historyStmt, err := tx.Prepare("INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+
+	deleteStmt, err := tx.Prepare("DELETE FROM parcel WHERE number = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteBatch{
+		addStmt:       addStmt,
+		getStatusStmt: getStatusStmt,
+		setStatusStmt: setStatusStmt,
+		historyStmt:   historyStmt,
+		deleteStmt:    deleteStmt,
+	}, nil
+}
+
+func (b *sqliteBatch) close() {
+	b.addStmt.Close()
+	b.getStatusStmt.Close()
+	b.setStatusStmt.Close()
+	b.historyStmt.Close()
+	b.deleteStmt.Close()
+}
+
+func (b *sqliteBatch) status(ctx context.Context, number int) (ParcelStatus, error) {
+	var status ParcelStatus
+	err := b.getStatusStmt.QueryRowContext(ctx, number).Scan(&status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+		}
+		return "", fmt.Errorf("get parcel %d: %w", number, err)
+	}
+
+	return status, nil
+}
+
+func (b *sqliteBatch) AddMany(ctx context.Context, parcels []Parcel) ([]int, error) {
+	ids := make([]int, 0, len(parcels))
+	for _, p := range parcels {
+		res, err := b.addStmt.ExecContext(ctx, p.Client, p.Status, p.Address, p.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("add parcel: %w", err)
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("add parcel: %w", err)
+		}
+
+		ids = append(ids, int(id))
+	}
+
+	return ids, nil
+}
+
+func (b *sqliteBatch) SetStatusMany(ctx context.Context, numbers []int, status ParcelStatus) error {
+	if err := validateStatus(status); err != nil {
+		return err
+	}
+
+	for _, number := range numbers {
+		current, err := b.status(ctx, number)
+		if err != nil {
+			return err
+		}
+		if !validTransition(current, status) {
+			return ErrInvalidTransition
+		}
+
+		if _, err := b.setStatusStmt.ExecContext(ctx, status, number); err != nil {
+			return fmt.Errorf("set status for parcel %d: %w", number, err)
+		}
+		_, err = b.historyStmt.ExecContext(ctx, number, current, status, time.Now().UTC(), actorFromContext(ctx))
+		if err != nil {
+			return fmt.Errorf("record status history for parcel %d: %w", number, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *sqliteBatch) DeleteMany(ctx context.Context, numbers []int) error {
+	for _, number := range numbers {
+		status, err := b.status(ctx, number)
+		if err != nil {
+			return err
+		}
+		if status != ParcelStatusRegistered {
+			return ErrImmutable
+		}
+
+		if _, err := b.deleteStmt.ExecContext(ctx, number); err != nil {
+			return fmt.Errorf("delete parcel %d: %w", number, err)
+		}
+	}
+
+	return nil
+}
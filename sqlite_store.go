@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteDSNPragmas are applied to every connection this package opens
+// against SQLite, via modernc.org/sqlite's "_pragma" DSN query parameter
+// (unlike a one-off db.Exec("PRAGMA ..."), this runs for every connection a
+// pool hands out, not just whichever one happened to run the Exec).
+//
+//   - busy_timeout: without it, a writer that loses a lock conflict gets an
+//     immediate "database is locked" error instead of waiting for the
+//     holder to commit, so any real concurrency at all surfaces as
+//     spurious failures rather than being serialized.
+//   - foreign_keys: SQLite parses but ignores REFERENCES constraints
+//     unless a connection opts in, so parcel_status_history's reference to
+//     parcel wouldn't actually be enforced without this.
+const sqliteDSNPragmas = "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+
+// withSQLiteDSNPragmas appends sqliteDSNPragmas to dsn, whether or not dsn
+// already carries its own query string.
+func withSQLiteDSNPragmas(dsn string) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + sqliteDSNPragmas
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx, so sqliteParcelStore
+// can run unchanged against a plain connection or inside a transaction.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// sqliteParcelStore is the database/sql-backed ParcelStore, used with the
+// modernc.org/sqlite driver.
+type sqliteParcelStore struct {
+	db sqlExecer
+}
+
+// NewSQLiteParcelStore returns a ParcelStore backed by a *sql.DB. The caller
+// is responsible for opening the connection and running MigrateSQLite first.
+func NewSQLiteParcelStore(db *sql.DB) ParcelStore {
+	return sqliteParcelStore{db: db}
+}
+
+func (s sqliteParcelStore) Add(ctx context.Context, p Parcel) (int, error) {
+	res, err := s.db.ExecContext(ctx, "INSERT INTO parcel (client, status, address, created_at) VALUES (?, ?, ?, ?)",
+		p.Client, p.Status, p.Address, p.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("add parcel: %w", err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("add parcel: %w", err)
+	}
+
+	return int(id), nil
+}
+
+func (s sqliteParcelStore) Get(ctx context.Context, number int) (Parcel, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT number, client, status, address, created_at FROM parcel WHERE number = ?", number)
+
+	var p Parcel
+	err := row.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Parcel{}, fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+		}
+		return Parcel{}, fmt.Errorf("get parcel %d: %w", number, err)
+	}
+
+	return p, nil
+}
+
+func (s sqliteParcelStore) GetByClient(ctx context.Context, client int) ([]Parcel, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT number, client, status, address, created_at FROM parcel WHERE client = ?", client)
+	if err != nil {
+		return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+	}
+	defer rows.Close()
+
+	var parcels []Parcel
+	for rows.Next() {
+		var p Parcel
+		if err := rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+		}
+		parcels = append(parcels, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get parcels for client %d: %w", client, err)
+	}
+
+	return parcels, nil
+}
+
+func (s sqliteParcelStore) Query(ctx context.Context, filter ParcelFilter) ([]Parcel, *Cursor, error) {
+	limit := normalizeLimit(filter.Limit)
+
+	query := "SELECT number, client, status, address, created_at FROM parcel WHERE client = ?"
+	args := []any{filter.Client}
+
+	if filter.Status != nil {
+		query += " AND status = ?"
+		args = append(args, *filter.Status)
+	}
+	if filter.CreatedAfter != nil {
+		query += " AND created_at > ?"
+		args = append(args, *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query += " AND created_at < ?"
+		args = append(args, *filter.CreatedBefore)
+	}
+	if filter.AddressLike != "" {
+		query += " AND address LIKE ?"
+		args = append(args, "%"+filter.AddressLike+"%")
+	}
+	if filter.After != nil {
+		switch filter.OrderBy {
+		case OrderCreatedAtDesc:
+			query += " AND (created_at < ? OR (created_at = ? AND number < ?))"
+			args = append(args, filter.After.CreatedAt, filter.After.CreatedAt, filter.After.Number)
+		case OrderNumberAsc:
+			query += " AND number > ?"
+			args = append(args, filter.After.Number)
+		case OrderNumberDesc:
+			query += " AND number < ?"
+			args = append(args, filter.After.Number)
+		default: // OrderCreatedAtAsc
+			query += " AND (created_at > ? OR (created_at = ? AND number > ?))"
+			args = append(args, filter.After.CreatedAt, filter.After.CreatedAt, filter.After.Number)
+		}
+	}
+
+	query += " ORDER BY " + orderByClause(filter.OrderBy) + " LIMIT ?"
+	args = append(args, limit+1) // one extra row tells us whether there's a next page
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+	}
+	defer rows.Close()
+
+	var parcels []Parcel
+	for rows.Next() {
+		var p Parcel
+		if err := rows.Scan(&p.Number, &p.Client, &p.Status, &p.Address, &p.CreatedAt); err != nil {
+			return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+		}
+		parcels = append(parcels, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("query parcels for client %d: %w", filter.Client, err)
+	}
+
+	var next *Cursor
+	if len(parcels) > limit {
+		parcels = parcels[:limit]
+		last := parcels[len(parcels)-1]
+		next = &Cursor{CreatedAt: last.CreatedAt, Number: last.Number}
+	}
+
+	return parcels, next, nil
+}
+
+func (s sqliteParcelStore) SetStatus(ctx context.Context, number int, status ParcelStatus) error {
+	if err := validateStatus(status); err != nil {
+		return err
+	}
+
+	// The read, transition check, and write all happen inside the same
+	// transaction, with the UPDATE guarded by the status we just read.
+	// Without busy_timeout, SQLite hands back an immediate "database is
+	// locked" error to whichever side loses a lock conflict rather than
+	// blocking, so the guard is the actual backstop: if a concurrent
+	// SetStatus on the same parcel still manages to commit a different
+	// status in between our read and our write, the guarded UPDATE affects
+	// zero rows and we report ErrConcurrentUpdate instead of silently
+	// overwriting on top of a stale "from" status.
+	apply := func(db sqlExecer) error {
+		row := db.QueryRowContext(ctx, "SELECT status FROM parcel WHERE number = ?", number)
+		var current ParcelStatus
+		if err := row.Scan(&current); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("get parcel %d: %w", number, ErrNotFound)
+			}
+			return fmt.Errorf("get parcel %d: %w", number, err)
+		}
+		if !validTransition(current, status) {
+			return ErrInvalidTransition
+		}
+
+		res, err := db.ExecContext(ctx, "UPDATE parcel SET status = ? WHERE number = ? AND status = ?", status, number, current)
+		if err != nil {
+			return fmt.Errorf("set status for parcel %d: %w", number, err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("set status for parcel %d: %w", number, err)
+		}
+		if affected == 0 {
+			return fmt.Errorf("set status for parcel %d: %w", number, ErrConcurrentUpdate)
+		}
+
+		_, err = db.ExecContext(ctx,
+			"INSERT INTO parcel_status_history (parcel_number, from_status, to_status, changed_at, actor) VALUES (?, ?, ?, ?, ?)",
+			number, current, status, time.Now().UTC(), actorFromContext(ctx))
+		if err != nil {
+			return fmt.Errorf("record status history for parcel %d: %w", number, err)
+		}
+		return nil
+	}
+
+	db, ok := s.db.(*sql.DB)
+	if !ok {
+		// Already running inside a caller's transaction (e.g. WithTx):
+		// both writes land atomically as part of that transaction.
+		return apply(s.db)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := apply(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s sqliteParcelStore) History(ctx context.Context, number int) ([]StatusChange, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT parcel_number, from_status, to_status, changed_at, actor FROM parcel_status_history WHERE parcel_number = ? ORDER BY id ASC",
+		number)
+	if err != nil {
+		return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+	}
+	defer rows.Close()
+
+	var history []StatusChange
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.ParcelNumber, &c.From, &c.To, &c.ChangedAt, &c.Actor); err != nil {
+			return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+		}
+		history = append(history, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get status history for parcel %d: %w", number, err)
+	}
+
+	return history, nil
+}
+
+func (s sqliteParcelStore) SetAddress(ctx context.Context, number int, address string) error {
+	p, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrImmutable
+	}
+
+	if _, err := s.db.ExecContext(ctx, "UPDATE parcel SET address = ? WHERE number = ?", address, number); err != nil {
+		return fmt.Errorf("set address for parcel %d: %w", number, err)
+	}
+
+	return nil
+}
+
+func (s sqliteParcelStore) Delete(ctx context.Context, number int) error {
+	p, err := s.Get(ctx, number)
+	if err != nil {
+		return err
+	}
+	if p.Status != ParcelStatusRegistered {
+		return ErrImmutable
+	}
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM parcel WHERE number = ?", number); err != nil {
+		return fmt.Errorf("delete parcel %d: %w", number, err)
+	}
+
+	return nil
+}
+
+// withTx runs fn against a sqliteParcelStore backed by a single *sql.Tx,
+// committing on success and rolling back if fn (or the commit) fails.
+func (s sqliteParcelStore) withTx(ctx context.Context, fn func(tx ParcelStore) error) error {
+	db, ok := s.db.(*sql.DB)
+	if !ok {
+		return errors.New("withTx: store is already inside a transaction")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	if err := fn(sqliteParcelStore{db: tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// withBatch runs fn against a Batch backed by a single *sql.Tx and prepared
+// statements, committing on success and rolling back if fn (or the commit)
+// fails.
+func (s sqliteParcelStore) withBatch(ctx context.Context, fn func(b Batch) error) error {
+	db, ok := s.db.(*sql.DB)
+	if !ok {
+		return errors.New("withBatch: store is already inside a transaction")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+
+	b, err := newSQLiteBatch(tx)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("prepare batch: %w", err)
+	}
+	defer b.close()
+
+	if err := fn(b); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import "context"
+
+// ParcelStore is the persistence boundary for parcels. The sqlite-backed
+// implementation in sqlite_store.go and the Postgres/CockroachDB
+// implementation in postgres_store.go are both just drivers behind this
+// interface, so callers never depend on database/sql or pgx directly. Every
+// method takes a context so callers can bound or cancel a request without
+// reaching into the driver.
+type ParcelStore interface {
+	Add(ctx context.Context, p Parcel) (int, error)
+	Get(ctx context.Context, number int) (Parcel, error)
+	GetByClient(ctx context.Context, client int) ([]Parcel, error)
+	// Query is a filtered, sorted, keyset-paginated version of GetByClient:
+	// pass the Cursor it returns as filter.After to fetch the next page.
+	// A nil returned Cursor means there is no next page.
+	Query(ctx context.Context, filter ParcelFilter) ([]Parcel, *Cursor, error)
+	SetStatus(ctx context.Context, number int, status ParcelStatus) error
+	SetAddress(ctx context.Context, number int, address string) error
+	Delete(ctx context.Context, number int) error
+	// History returns every status transition recorded for a parcel,
+	// oldest first.
+	History(ctx context.Context, number int) ([]StatusChange, error)
+}
+
+// validateStatus rejects any status the store doesn't recognize, shared by
+// every ParcelStore implementation's SetStatus.
+func validateStatus(status ParcelStatus) error {
+	switch status {
+	case ParcelStatusRegistered, ParcelStatusSent, ParcelStatusDelivered, ParcelStatusCancelled:
+		return nil
+	default:
+		return ErrInvalidStatus
+	}
+}
+
+// validTransition reports whether a parcel may move from one status to
+// another. The state machine is: registered -> sent -> delivered, with
+// registered -> cancelled as the only way out early. Every other move,
+// including re-entering a status a parcel already left, is invalid.
+func validTransition(from, to ParcelStatus) bool {
+	switch {
+	case from == ParcelStatusRegistered && to == ParcelStatusSent:
+		return true
+	case from == ParcelStatusRegistered && to == ParcelStatusCancelled:
+		return true
+	case from == ParcelStatusSent && to == ParcelStatusDelivered:
+		return true
+	default:
+		return false
+	}
+}
+
+// actorContextKey is the context.Value key ContextWithActor stores under.
+type actorContextKey struct{}
+
+// ContextWithActor attaches the identity of whoever is making a status
+// change, so it ends up on the corresponding StatusChange.Actor. Stores that
+// don't find one recorded in ctx fall back to "system".
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorContextKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return "system"
+}
+
+const (
+	defaultQueryLimit = 50
+	maxQueryLimit     = 500
+)
+
+// normalizeLimit clamps a requested page size to a sane range shared by
+// every ParcelStore implementation's Query.
+func normalizeLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return defaultQueryLimit
+	case limit > maxQueryLimit:
+		return maxQueryLimit
+	default:
+		return limit
+	}
+}
+
+// orderByClause renders the ORDER BY for a SortOrder. Ties on created_at are
+// broken by number so results (and cursors) stay stable and unambiguous.
+func orderByClause(order SortOrder) string {
+	switch order {
+	case OrderCreatedAtDesc:
+		return "created_at DESC, number DESC"
+	case OrderNumberAsc:
+		return "number ASC"
+	case OrderNumberDesc:
+		return "number DESC"
+	default:
+		return "created_at ASC, number ASC"
+	}
+}